@@ -6,9 +6,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"net/http"
+	"os"
 	"regexp"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
@@ -22,28 +25,532 @@ import (
 	"github.com/pkg/errors"
 )
 
+// MetricsAPI is the subset of monitoring.MonitoringClient this plugin calls,
+// extracted so the audit-logging middleware (and tests) can wrap or fake it.
+type MetricsAPI interface {
+	SetRegion(region string)
+	ListMetrics(ctx context.Context, request monitoring.ListMetricsRequest) (monitoring.ListMetricsResponse, error)
+	SummarizeMetricsData(ctx context.Context, request monitoring.SummarizeMetricsDataRequest) (monitoring.SummarizeMetricsDataResponse, error)
+	ListAlarmsStatus(ctx context.Context, request monitoring.ListAlarmsStatusRequest) (monitoring.ListAlarmsStatusResponse, error)
+	GetAlarmHistory(ctx context.Context, request monitoring.GetAlarmHistoryRequest) (monitoring.GetAlarmHistoryResponse, error)
+}
+
+// IdentityAPI is the subset of identity.IdentityClient this plugin calls,
+// extracted for the same reason as MetricsAPI above.
+type IdentityAPI interface {
+	SetRegion(region string)
+	GetTenancy(ctx context.Context, request identity.GetTenancyRequest) (identity.GetTenancyResponse, error)
+	ListCompartments(ctx context.Context, request identity.ListCompartmentsRequest) (identity.ListCompartmentsResponse, error)
+	ListRegions(ctx context.Context) (identity.ListRegionsResponse, error)
+	ListRegionSubscriptions(ctx context.Context, request identity.ListRegionSubscriptionsRequest) (identity.ListRegionSubscriptionsResponse, error)
+}
+
 //how often to refresh our compartmentID cache
 var cacheRefreshTime = time.Minute
 
+// maxRegionWorkers bounds how many regions we fan a single query out to
+// concurrently, so a "Region: all" query can't open unbounded OCI connections.
+const maxRegionWorkers = 5
+
 //OCIDatasource - pulls in data from telemtry/various oci apis
 type OCIDatasource struct {
 	plugin.NetRPCUnsupportedPlugin
-	metricsClient    monitoring.MonitoringClient
-	identityClient   identity.IdentityClient
-	config           common.ConfigurationProvider
-	logger           hclog.Logger
-	nameToOCID       map[string]string
-	timeCacheUpdated time.Time
+	logger hclog.Logger
+
+	compartmentCache *compartmentCache
+
+	// queryTimeoutOverride, when set, fixes the per-query deadline instead
+	// of it being derived from the panel's interval/maxDataPoints. Zero
+	// means "derive it" (see queryTimeout).
+	queryTimeoutOverride time.Duration
+
+	regionClientsMu sync.RWMutex
+	regionClients   map[regionClientKey]MetricsAPI
+
+	clientsMu sync.RWMutex
+	clients   map[clientKey]*ociClients
+
+	auditMu sync.RWMutex
+	// audit holds one ring buffer and file path per clientKey, the same
+	// identity selection clients/regionClients are scoped by. A single
+	// OCIDatasource can serve several distinct identities (different
+	// tenancies, or a rotated raw credential), so the audit trail is kept
+	// per identity too - otherwise one tenant's configured file path or
+	// audit records would race with, or leak into, another's.
+	audit map[clientKey]*auditScope
+}
+
+// auditScope is the per-clientKey audit state: the ring buffer the
+// "auditlog" query type reads from, and the file path (set from that
+// identity's datasource config jsonData) every record is additionally
+// mirrored to.
+type auditScope struct {
+	ring *auditRing
+
+	pathMu sync.RWMutex
+	// path, when set, mirrors every audit record to this file in addition
+	// to ring and the plugin's own structured log. It's updated on every
+	// Query call rather than fixed at construction time, since a running
+	// data source can have its config edited without a fresh plugin
+	// instance being started.
+	path string
 }
 
+func newAuditScope() *auditScope {
+	return &auditScope{ring: newAuditRing(auditLogCapacity)}
+}
+
+func (s *auditScope) setFilePath(path string) {
+	s.pathMu.Lock()
+	defer s.pathMu.Unlock()
+	s.path = path
+}
+
+func (s *auditScope) filePath() string {
+	s.pathMu.RLock()
+	defer s.pathMu.RUnlock()
+	return s.path
+}
+
+// auditScopeFor returns the auditScope for key, building one on first use.
+func (o *OCIDatasource) auditScopeFor(key clientKey) *auditScope {
+	o.auditMu.RLock()
+	scope, ok := o.audit[key]
+	o.auditMu.RUnlock()
+	if ok {
+		return scope
+	}
+
+	o.auditMu.Lock()
+	defer o.auditMu.Unlock()
+	if scope, ok := o.audit[key]; ok {
+		return scope
+	}
+	scope = newAuditScope()
+	o.audit[key] = scope
+	return scope
+}
+
+// clientKey identifies a distinct OCI identity/config selection. environment,
+// profile and tenancy together determine which ConfigurationProvider to
+// build for every environment except "OCI Raw", where the tenancy OCID alone
+// doesn't pin down the credential: two raw requests can share a tenancy but
+// carry different inline credentials (a rotated key, or two distinct raw
+// data sources), so userOCID/fingerprint/privateKey are folded in too for
+// that environment. This lets a single data source instance serve several
+// identities without tearing down and rebuilding clients on every switch.
+type clientKey struct {
+	environment string
+	profile     string
+	tenancy     string
+	userOCID    string
+	fingerprint string
+	privateKey  string
+}
+
+// ociClients bundles the config provider and SDK clients built for one
+// clientKey, plus the key itself so code holding a *ociClients can derive
+// cache keys for things scoped below it (region clients, compartment cache
+// entries) without needing the key passed in separately.
+type ociClients struct {
+	key            clientKey
+	config         common.ConfigurationProvider
+	metricsClient  MetricsAPI
+	identityClient IdentityAPI
+}
+
+// regionClientKey scopes a cached per-region MetricsAPI client by both the
+// identity selection that built it and the region, since the same region
+// needs a different client per identity.
+type regionClientKey struct {
+	client clientKey
+	region string
+}
+
+const auditLogCapacity = 200
+
 //NewOCIDatasource - constructor
 func NewOCIDatasource(pluginLogger hclog.Logger) (*OCIDatasource, error) {
-	m := make(map[string]string)
+	o := &OCIDatasource{
+		logger:           pluginLogger,
+		compartmentCache: newCompartmentCache(),
+		regionClients:    make(map[regionClientKey]MetricsAPI),
+		clients:          make(map[clientKey]*ociClients),
+		audit:            make(map[clientKey]*auditScope),
+	}
+	o.startCompartmentCacheRefresher()
+	return o, nil
+}
 
-	return &OCIDatasource{
-		logger:     pluginLogger,
-		nameToOCID: m,
-	}, nil
+// useClients returns the *ociClients for key, building and caching them on
+// first use. Returning the clients rather than stashing them on o means two
+// concurrent Query calls for different keys (different tenancy/profile/
+// environment) never interfere with each other. Caching per (environment,
+// profile, tenancy[, raw credential]) tuple instead of a single config field
+// means switching the selection on a running data source takes effect on
+// the very next query instead of requiring a fresh plugin instance.
+func (o *OCIDatasource) useClients(key clientKey, raw GrafanaCommonRequest) (*ociClients, error) {
+	o.clientsMu.RLock()
+	clients, ok := o.clients[key]
+	o.clientsMu.RUnlock()
+
+	if ok {
+		return clients, nil
+	}
+
+	o.clientsMu.Lock()
+	defer o.clientsMu.Unlock()
+	if clients, ok = o.clients[key]; ok {
+		return clients, nil
+	}
+
+	configProvider, err := getConfigProvider(key.environment, key.profile, raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "broken environment")
+	}
+	metricsClient, err := monitoring.NewMonitoringClientWithConfigurationProvider(configProvider)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating monitoring client")
+	}
+	identityClient, err := identity.NewIdentityClientWithConfigurationProvider(configProvider)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating identity client")
+	}
+	clients = &ociClients{
+		key:            key,
+		config:         configProvider,
+		metricsClient:  &auditingMetricsClient{MetricsAPI: &metricsClient, o: o, key: key},
+		identityClient: &auditingIdentityClient{IdentityAPI: &identityClient, o: o, key: key},
+	}
+	o.clients[key] = clients
+	return clients, nil
+}
+
+// metricsClientForRegion returns a MonitoringClient already set to region,
+// creating and caching one the first time the (clients, region) pair is
+// seen. This replaces calling clients.metricsClient.SetRegion(...) per
+// query, which races when queries for different regions run concurrently.
+func (o *OCIDatasource) metricsClientForRegion(clients *ociClients, region string) (MetricsAPI, error) {
+	cacheKey := regionClientKey{client: clients.key, region: region}
+
+	o.regionClientsMu.RLock()
+	client, ok := o.regionClients[cacheKey]
+	o.regionClientsMu.RUnlock()
+	if ok {
+		return client, nil
+	}
+
+	o.regionClientsMu.Lock()
+	defer o.regionClientsMu.Unlock()
+	if client, ok := o.regionClients[cacheKey]; ok {
+		return client, nil
+	}
+
+	rawClient, err := monitoring.NewMonitoringClientWithConfigurationProvider(clients.config)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error creating monitoring client for region %s", region))
+	}
+	rawClient.SetRegion(string(common.StringToRegion(region)))
+	client = &auditingMetricsClient{MetricsAPI: &rawClient, region: region, o: o, key: clients.key}
+	o.regionClients[cacheKey] = client
+	return client, nil
+}
+
+// resolveRegions expands a query's Region field into the concrete list of
+// regions to fan out to: "all" (case-insensitive) expands to every region the
+// tenancy is subscribed to, a comma-separated list is split and trimmed, and
+// anything else is treated as a single region.
+func (o *OCIDatasource) resolveRegions(ctx context.Context, clients *ociClients, tenancyOCID, region string) ([]string, error) {
+	if strings.EqualFold(strings.TrimSpace(region), "all") {
+		res, err := clients.identityClient.ListRegionSubscriptions(ctx, identity.ListRegionSubscriptionsRequest{TenancyId: common.String(tenancyOCID)})
+		if err != nil {
+			return nil, errors.Wrap(err, "error listing region subscriptions")
+		}
+		regions := make([]string, 0, len(res.Items))
+		for _, sub := range res.Items {
+			regions = append(regions, *sub.RegionName)
+		}
+		return regions, nil
+	}
+
+	parts := strings.Split(region, ",")
+	regions := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			regions = append(regions, p)
+		}
+	}
+	if len(regions) == 0 {
+		return nil, errors.New("region is required")
+	}
+	return regions, nil
+}
+
+// auditCallerKey stashes the query type making the current batch of OCI API
+// calls onto the context, so the audited clients below can attribute a call
+// without threading an extra parameter through every helper.
+type auditCallerKey struct{}
+
+func withAuditCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, auditCallerKey{}, caller)
+}
+
+func auditCallerFromContext(ctx context.Context) string {
+	if caller, ok := ctx.Value(auditCallerKey{}).(string); ok {
+		return caller
+	}
+	return "unknown"
+}
+
+// auditRecord is one structured audit line: an OCI API call made on behalf
+// of a Grafana query.
+type auditRecord struct {
+	Time         time.Time
+	Caller       string
+	Region       string
+	Compartment  string
+	RequestKind  string
+	StatusCode   int
+	OpcRequestID string
+	Latency      time.Duration
+	// Bytes is the response body size in bytes (the HTTP Content-Length),
+	// not an item/entry count, so it reflects how much data actually came
+	// back over the wire.
+	Bytes int64
+}
+
+// auditRing is a fixed-capacity, concurrency-safe ring buffer of the most
+// recent audit records, exposed through the "auditlog" query type so an
+// operator can diagnose throttling (HTTP 429) and permission errors from
+// inside Grafana without shell access to the plugin host.
+type auditRing struct {
+	mu      sync.Mutex
+	records []auditRecord
+	next    int
+	full    bool
+}
+
+func newAuditRing(capacity int) *auditRing {
+	return &auditRing{records: make([]auditRecord, capacity)}
+}
+
+func (r *auditRing) add(rec auditRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records[r.next] = rec
+	r.next = (r.next + 1) % len(r.records)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// snapshot returns the ring's contents in chronological order.
+func (r *auditRing) snapshot() []auditRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		out := make([]auditRecord, r.next)
+		copy(out, r.records[:r.next])
+		return out
+	}
+	out := make([]auditRecord, len(r.records))
+	n := copy(out, r.records[r.next:])
+	copy(out[n:], r.records[:r.next])
+	return out
+}
+
+// recordAudit appends rec to key's in-memory ring, emits it as a structured
+// hclog line, and (if that identity's audit file path is configured)
+// mirrors it to that file too. Scoping by key keeps two identities served by
+// the same OCIDatasource (different tenancies, or a rotated raw credential)
+// from reading or overwriting each other's audit trail.
+func (o *OCIDatasource) recordAudit(key clientKey, rec auditRecord) {
+	rec.Time = time.Now()
+	scope := o.auditScopeFor(key)
+	scope.ring.add(rec)
+
+	o.logger.Info("oci api call",
+		"caller", rec.Caller,
+		"region", rec.Region,
+		"compartment", rec.Compartment,
+		"request", rec.RequestKind,
+		"status", rec.StatusCode,
+		"opc-request-id", rec.OpcRequestID,
+		"latency_ms", rec.Latency.Milliseconds(),
+		"bytes", rec.Bytes,
+	)
+
+	path := scope.filePath()
+	if path == "" {
+		return
+	}
+	line := fmt.Sprintf("%s caller=%s region=%s compartment=%s request=%s status=%d opc-request-id=%s latency_ms=%d bytes=%d\n",
+		rec.Time.Format(time.RFC3339), rec.Caller, rec.Region, rec.Compartment, rec.RequestKind,
+		rec.StatusCode, rec.OpcRequestID, rec.Latency.Milliseconds(), rec.Bytes)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		o.logger.Error("failed to open audit log file", "path", path, "error", err.Error())
+		return
+	}
+	defer f.Close()
+	if _, err := f.WriteString(line); err != nil {
+		o.logger.Error("failed to write audit log file", "path", path, "error", err.Error())
+	}
+}
+
+// rawResponseMeta pulls the HTTP status code, opc-request-id and response
+// body size out of an OCI SDK RawResponse, tolerating a nil response (e.g.
+// the call never made it to the wire). bytes is 0 when the response didn't
+// report a Content-Length (e.g. chunked encoding), rather than net/http's -1
+// sentinel for "unknown".
+func rawResponseMeta(raw *http.Response) (status int, opcRequestID string, bytes int64) {
+	if raw == nil {
+		return 0, "", 0
+	}
+	if raw.ContentLength > 0 {
+		bytes = raw.ContentLength
+	}
+	return raw.StatusCode, raw.Header.Get("opc-request-id"), bytes
+}
+
+// auditingMetricsClient wraps a MetricsAPI and records a structured audit
+// entry for every call it makes, so throttling and permission errors can be
+// diagnosed from inside Grafana (see the "auditlog" query type).
+type auditingMetricsClient struct {
+	MetricsAPI
+	region string
+	key    clientKey
+	o      *OCIDatasource
+}
+
+func (a *auditingMetricsClient) SetRegion(region string) {
+	a.region = region
+	a.MetricsAPI.SetRegion(region)
+}
+
+func (a *auditingMetricsClient) ListMetrics(ctx context.Context, request monitoring.ListMetricsRequest) (monitoring.ListMetricsResponse, error) {
+	start := time.Now()
+	res, err := a.MetricsAPI.ListMetrics(ctx, request)
+	status, opcRequestID, bytes := rawResponseMeta(res.RawResponse)
+	a.o.recordAudit(a.key, auditRecord{
+		Caller:       auditCallerFromContext(ctx),
+		Region:       a.region,
+		Compartment:  deref(request.CompartmentId),
+		RequestKind:  "ListMetrics",
+		StatusCode:   status,
+		OpcRequestID: opcRequestID,
+		Latency:      time.Since(start),
+		Bytes:        bytes,
+	})
+	return res, err
+}
+
+func (a *auditingMetricsClient) SummarizeMetricsData(ctx context.Context, request monitoring.SummarizeMetricsDataRequest) (monitoring.SummarizeMetricsDataResponse, error) {
+	start := time.Now()
+	res, err := a.MetricsAPI.SummarizeMetricsData(ctx, request)
+	status, opcRequestID, bytes := rawResponseMeta(res.RawResponse)
+	a.o.recordAudit(a.key, auditRecord{
+		Caller:       auditCallerFromContext(ctx),
+		Region:       a.region,
+		Compartment:  deref(request.CompartmentId),
+		RequestKind:  "SummarizeMetricsData",
+		StatusCode:   status,
+		OpcRequestID: opcRequestID,
+		Latency:      time.Since(start),
+		Bytes:        bytes,
+	})
+	return res, err
+}
+
+func (a *auditingMetricsClient) ListAlarmsStatus(ctx context.Context, request monitoring.ListAlarmsStatusRequest) (monitoring.ListAlarmsStatusResponse, error) {
+	start := time.Now()
+	res, err := a.MetricsAPI.ListAlarmsStatus(ctx, request)
+	status, opcRequestID, bytes := rawResponseMeta(res.RawResponse)
+	a.o.recordAudit(a.key, auditRecord{
+		Caller:       auditCallerFromContext(ctx),
+		Region:       a.region,
+		Compartment:  deref(request.CompartmentId),
+		RequestKind:  "ListAlarmsStatus",
+		StatusCode:   status,
+		OpcRequestID: opcRequestID,
+		Latency:      time.Since(start),
+		Bytes:        bytes,
+	})
+	return res, err
+}
+
+func (a *auditingMetricsClient) GetAlarmHistory(ctx context.Context, request monitoring.GetAlarmHistoryRequest) (monitoring.GetAlarmHistoryResponse, error) {
+	start := time.Now()
+	res, err := a.MetricsAPI.GetAlarmHistory(ctx, request)
+	status, opcRequestID, bytes := rawResponseMeta(res.RawResponse)
+	a.o.recordAudit(a.key, auditRecord{
+		Caller:       auditCallerFromContext(ctx),
+		Region:       a.region,
+		Compartment:  deref(request.AlarmId),
+		RequestKind:  "GetAlarmHistory",
+		StatusCode:   status,
+		OpcRequestID: opcRequestID,
+		Latency:      time.Since(start),
+		Bytes:        bytes,
+	})
+	return res, err
+}
+
+// auditingIdentityClient is the IdentityAPI counterpart of
+// auditingMetricsClient.
+type auditingIdentityClient struct {
+	IdentityAPI
+	region string
+	key    clientKey
+	o      *OCIDatasource
+}
+
+func (a *auditingIdentityClient) SetRegion(region string) {
+	a.region = region
+	a.IdentityAPI.SetRegion(region)
+}
+
+func (a *auditingIdentityClient) GetTenancy(ctx context.Context, request identity.GetTenancyRequest) (identity.GetTenancyResponse, error) {
+	start := time.Now()
+	res, err := a.IdentityAPI.GetTenancy(ctx, request)
+	status, opcRequestID, bytes := rawResponseMeta(res.RawResponse)
+	a.o.recordAudit(a.key, auditRecord{
+		Caller:       auditCallerFromContext(ctx),
+		Region:       a.region,
+		Compartment:  deref(request.TenancyId),
+		RequestKind:  "GetTenancy",
+		StatusCode:   status,
+		OpcRequestID: opcRequestID,
+		Latency:      time.Since(start),
+		Bytes:        bytes,
+	})
+	return res, err
+}
+
+func (a *auditingIdentityClient) ListCompartments(ctx context.Context, request identity.ListCompartmentsRequest) (identity.ListCompartmentsResponse, error) {
+	start := time.Now()
+	res, err := a.IdentityAPI.ListCompartments(ctx, request)
+	status, opcRequestID, bytes := rawResponseMeta(res.RawResponse)
+	a.o.recordAudit(a.key, auditRecord{
+		Caller:       auditCallerFromContext(ctx),
+		Region:       a.region,
+		Compartment:  deref(request.CompartmentId),
+		RequestKind:  "ListCompartments",
+		StatusCode:   status,
+		OpcRequestID: opcRequestID,
+		Latency:      time.Since(start),
+		Bytes:        bytes,
+	})
+	return res, err
+}
+
+// deref returns *s, or "" for a nil pointer. OCI SDK request fields are
+// *string almost everywhere, so this keeps the audit call sites above terse.
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
 }
 
 // GrafanaOCIRequest - Query Request comning in from the front end
@@ -74,54 +581,143 @@ type GrafanaCommonRequest struct {
 	QueryType   string
 	Region      string
 	TenancyOCID string `json:"tenancyOCID"`
+	// Profile selects a named profile from ~/.oci/config when Environment is
+	// "OCI User Principal".
+	Profile string `json:"profile,omitempty"`
+	// The remaining fields carry an inline credential block used when
+	// Environment is "OCI Raw" (e.g. credentials passed from a secrets
+	// manager rather than a config file on the plugin host).
+	UserOCID             string `json:"userOCID,omitempty"`
+	Fingerprint          string `json:"fingerprint,omitempty"`
+	PrivateKey           string `json:"privateKey,omitempty"`
+	PrivateKeyPassphrase string `json:"privateKeyPassphrase,omitempty"`
+}
+
+// datasourceJsonData is the subset of the data source's own jsonData (set on
+// the data source config page, not the per-panel query editor) that this
+// plugin reads. Unlike GrafanaCommonRequest, this comes from
+// tsdbReq.Datasource.JsonData, so a dashboard viewer editing a panel's query
+// can't influence it - only whoever has edit rights on the data source
+// itself can.
+type datasourceJsonData struct {
+	// AuditLogFilePath, when set, mirrors every audit record to this file
+	// on the plugin host in addition to the in-memory ring and structured
+	// log. See OCIDatasource.recordAudit.
+	AuditLogFilePath string `json:"auditLogFilePath,omitempty"`
 }
 
 // Query - Determine what kind of query we're making
+const (
+	// defaultQueryTimeout bounds a query when a panel's interval/maxDataPoints
+	// can't be used to derive a more specific deadline, and is also the floor
+	// for a derived deadline so a small panel never gets less time than a
+	// query with no interval/maxDataPoints.
+	defaultQueryTimeout = 30 * time.Second
+	// maxQueryTimeout caps a derived deadline so a panel asking for a huge
+	// number of data points over a wide interval can't hang indefinitely.
+	maxQueryTimeout = 5 * time.Minute
+)
+
+// queryTimeout derives a per-query deadline from the panel's refresh
+// interval and requested number of data points (Grafana sends both on every
+// query): their product approximates the width of the time range the panel
+// is asking OCI to cover, which is what actually drives how much pagination
+// searchHelper/getCompartments and a multi-region fan-out have to do. The
+// result is floored at defaultQueryTimeout and capped at maxQueryTimeout so
+// neither a tiny nor a huge panel request ends up effectively unbounded.
+// o.queryTimeoutOverride, when set, always wins.
+func (o *OCIDatasource) queryTimeout(tsdbReq *datasource.DatasourceRequest) time.Duration {
+	if o.queryTimeoutOverride > 0 {
+		return o.queryTimeoutOverride
+	}
+	if len(tsdbReq.Queries) > 0 {
+		if q := tsdbReq.Queries[0]; q.IntervalMs > 0 && q.MaxDataPoints > 0 {
+			d := time.Duration(q.IntervalMs) * time.Duration(q.MaxDataPoints) * time.Millisecond
+			if d < defaultQueryTimeout {
+				d = defaultQueryTimeout
+			}
+			if d > maxQueryTimeout {
+				d = maxQueryTimeout
+			}
+			return d
+		}
+	}
+	return defaultQueryTimeout
+}
+
+// whenCancelled runs fn in its own goroutine as soon as ctx is done. It is
+// the same shape as the stdlib's context.AfterFunc (Go 1.21+). It only runs
+// fn; in-flight OCI SDK calls stop on a disconnected Grafana panel (the
+// plugin's parent context being cancelled) because they are passed ctx
+// themselves. Call the returned stop func once the guarded work completes
+// normally.
+func whenCancelled(ctx context.Context, fn func()) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			fn()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
 func (o *OCIDatasource) Query(ctx context.Context, tsdbReq *datasource.DatasourceRequest) (*datasource.DatasourceResponse, error) {
 	var ts GrafanaCommonRequest
 	json.Unmarshal([]byte(tsdbReq.Queries[0].ModelJson), &ts)
 
 	queryType := ts.QueryType
-	if o.config == nil {
-		configProvider, err := getConfigProvider(ts.Environment)
-		if err != nil {
-			return nil, errors.Wrap(err, "broken environment")
-		}
-		metricsClient, err := monitoring.NewMonitoringClientWithConfigurationProvider(configProvider)
-		if err != nil {
-			return nil, errors.New(fmt.Sprint("error with client", spew.Sdump(configProvider), err.Error()))
-		}
-		identityClient, err := identity.NewIdentityClientWithConfigurationProvider(configProvider)
-		if err != nil {
-			log.Printf("error with client")
-			panic(err)
+	key := clientKey{environment: ts.Environment, profile: ts.Profile, tenancy: ts.TenancyOCID}
+	if ts.Environment == "OCI Raw" {
+		key.userOCID = ts.UserOCID
+		key.fingerprint = ts.Fingerprint
+		key.privateKey = ts.PrivateKey
+	}
+	clients, err := o.useClients(key, ts)
+	if err != nil {
+		return nil, err
+	}
+	if tsdbReq.Datasource != nil && tsdbReq.Datasource.JsonData != "" {
+		var jsonData datasourceJsonData
+		if err := json.Unmarshal([]byte(tsdbReq.Datasource.JsonData), &jsonData); err == nil {
+			o.auditScopeFor(key).setFilePath(jsonData.AuditLogFilePath)
 		}
-		o.identityClient = identityClient
-		o.metricsClient = metricsClient
-		o.config = configProvider
 	}
 
+	ctx, cancel := context.WithTimeout(ctx, o.queryTimeout(tsdbReq))
+	defer cancel()
+	ctx = withAuditCaller(ctx, queryType)
+
 	switch queryType {
 	case "compartments":
-		return o.compartmentsResponse(ctx, tsdbReq)
+		return o.compartmentsResponse(ctx, tsdbReq, clients)
+	case "cachestats":
+		return o.cacheStatsResponse(ctx, tsdbReq)
+	case "auditlog":
+		return o.auditLogResponse(ctx, tsdbReq, clients)
+	case "alarms":
+		return o.alarmsResponse(ctx, tsdbReq, clients)
+	case "alarmhistory":
+		return o.alarmHistoryResponse(ctx, tsdbReq, clients)
 	case "dimensions":
-		return o.dimensionResponse(ctx, tsdbReq)
+		return o.dimensionResponse(ctx, tsdbReq, clients)
 	case "namespaces":
-		return o.namespaceResponse(ctx, tsdbReq)
+		return o.namespaceResponse(ctx, tsdbReq, clients)
 	case "resourcegroups":
-		return o.resourcegroupsResponse(ctx, tsdbReq)
+		return o.resourcegroupsResponse(ctx, tsdbReq, clients)
 	case "regions":
-		return o.regionsResponse(ctx, tsdbReq)
+		return o.regionsResponse(ctx, tsdbReq, clients)
 	case "search":
-		return o.searchResponse(ctx, tsdbReq)
+		return o.searchResponse(ctx, tsdbReq, clients)
 	case "test":
-		return o.testResponse(ctx, tsdbReq)
+		return o.testResponse(ctx, tsdbReq, clients)
 	default:
-		return o.queryResponse(ctx, tsdbReq)
+		return o.queryResponse(ctx, tsdbReq, clients)
 	}
 }
 
-func (o *OCIDatasource) testResponse(ctx context.Context, tsdbReq *datasource.DatasourceRequest) (*datasource.DatasourceResponse, error) {
+func (o *OCIDatasource) testResponse(ctx context.Context, tsdbReq *datasource.DatasourceRequest, clients *ociClients) (*datasource.DatasourceResponse, error) {
 	var ts GrafanaCommonRequest
 	json.Unmarshal([]byte(tsdbReq.Queries[0].ModelJson), &ts)
 
@@ -129,8 +725,8 @@ func (o *OCIDatasource) testResponse(ctx context.Context, tsdbReq *datasource.Da
 		CompartmentId: common.String(ts.TenancyOCID),
 	}
 	reg := common.StringToRegion(ts.Region)
-	o.metricsClient.SetRegion(string(reg))
-	res, err := o.metricsClient.ListMetrics(ctx, listMetrics)
+	clients.metricsClient.SetRegion(string(reg))
+	res, err := clients.metricsClient.ListMetrics(ctx, listMetrics)
 	status := res.RawResponse.StatusCode
 	if status >= 200 && status < 300 {
 		return &datasource.DatasourceResponse{}, nil
@@ -138,7 +734,7 @@ func (o *OCIDatasource) testResponse(ctx context.Context, tsdbReq *datasource.Da
 	return nil, errors.Wrap(err, fmt.Sprintf("list metrircs failed %s %d", spew.Sdump(res), status))
 }
 
-func (o *OCIDatasource) dimensionResponse(ctx context.Context, tsdbReq *datasource.DatasourceRequest) (*datasource.DatasourceResponse, error) {
+func (o *OCIDatasource) dimensionResponse(ctx context.Context, tsdbReq *datasource.DatasourceRequest, clients *ociClients) (*datasource.DatasourceResponse, error) {
 	table := datasource.Table{
 		Columns: []*datasource.TableColumn{
 			&datasource.TableColumn{Name: "text"},
@@ -146,6 +742,7 @@ func (o *OCIDatasource) dimensionResponse(ctx context.Context, tsdbReq *datasour
 		Rows: make([]*datasource.TableRow, 0),
 	}
 
+	var partialErr error
 	for _, query := range tsdbReq.Queries {
 		var ts GrafanaSearchRequest
 		json.Unmarshal([]byte(query.ModelJson), &ts)
@@ -155,10 +752,13 @@ func (o *OCIDatasource) dimensionResponse(ctx context.Context, tsdbReq *datasour
 			reqDetails.ResourceGroup = common.String(ts.ResourceGroup)
 		}
 		reqDetails.Name = common.String(ts.Metric)
-		items, err := o.searchHelper(ctx, ts.Region, ts.Compartment, reqDetails)
-		if err != nil {
+		items, err := o.searchHelperMultiRegion(ctx, clients, ts.TenancyOCID, ts.Region, ts.Compartment, reqDetails)
+		if err != nil && len(items) == 0 {
 			return nil, errors.Wrap(err, fmt.Sprint("list metrircs failed", spew.Sdump(reqDetails)))
 		}
+		if err != nil {
+			partialErr = err
+		}
 		rows := make([]*datasource.TableRow, 0)
 		for _, item := range items {
 			for dimension, value := range item.Dimensions {
@@ -174,33 +774,39 @@ func (o *OCIDatasource) dimensionResponse(ctx context.Context, tsdbReq *datasour
 		}
 		table.Rows = rows
 	}
+	res := &datasource.QueryResult{
+		RefId:  "dimensions",
+		Tables: []*datasource.Table{&table},
+	}
+	if partialErr != nil {
+		res.Error = partialErr.Error()
+	}
 	return &datasource.DatasourceResponse{
-		Results: []*datasource.QueryResult{
-			&datasource.QueryResult{
-				RefId:  "dimensions",
-				Tables: []*datasource.Table{&table},
-			},
-		},
+		Results: []*datasource.QueryResult{res},
 	}, nil
 }
 
-func (o *OCIDatasource) namespaceResponse(ctx context.Context, tsdbReq *datasource.DatasourceRequest) (*datasource.DatasourceResponse, error) {
+func (o *OCIDatasource) namespaceResponse(ctx context.Context, tsdbReq *datasource.DatasourceRequest, clients *ociClients) (*datasource.DatasourceResponse, error) {
 	table := datasource.Table{
 		Columns: []*datasource.TableColumn{
 			&datasource.TableColumn{Name: "text"},
 		},
 		Rows: make([]*datasource.TableRow, 0),
 	}
+	var partialErr error
 	for _, query := range tsdbReq.Queries {
 		var ts GrafanaSearchRequest
 		json.Unmarshal([]byte(query.ModelJson), &ts)
 
 		reqDetails := monitoring.ListMetricsDetails{}
 		reqDetails.GroupBy = []string{"namespace"}
-		items, err := o.searchHelper(ctx, ts.Region, ts.Compartment, reqDetails)
-		if err != nil {
+		items, err := o.searchHelperMultiRegion(ctx, clients, ts.TenancyOCID, ts.Region, ts.Compartment, reqDetails)
+		if err != nil && len(items) == 0 {
 			return nil, errors.Wrap(err, fmt.Sprint("list metrircs failed", spew.Sdump(reqDetails)))
 		}
+		if err != nil {
+			partialErr = err
+		}
 
 		rows := make([]*datasource.TableRow, 0)
 		for _, item := range items {
@@ -215,17 +821,19 @@ func (o *OCIDatasource) namespaceResponse(ctx context.Context, tsdbReq *datasour
 		}
 		table.Rows = rows
 	}
+	res := &datasource.QueryResult{
+		RefId:  "namespaces",
+		Tables: []*datasource.Table{&table},
+	}
+	if partialErr != nil {
+		res.Error = partialErr.Error()
+	}
 	return &datasource.DatasourceResponse{
-		Results: []*datasource.QueryResult{
-			&datasource.QueryResult{
-				RefId:  "namespaces",
-				Tables: []*datasource.Table{&table},
-			},
-		},
+		Results: []*datasource.QueryResult{res},
 	}, nil
 }
 
-func (o *OCIDatasource) resourcegroupsResponse(ctx context.Context, tsdbReq *datasource.DatasourceRequest) (*datasource.DatasourceResponse, error) {
+func (o *OCIDatasource) resourcegroupsResponse(ctx context.Context, tsdbReq *datasource.DatasourceRequest, clients *ociClients) (*datasource.DatasourceResponse, error) {
 	table := datasource.Table{
 		Columns: []*datasource.TableColumn{
 			&datasource.TableColumn{Name: "text"},
@@ -235,6 +843,7 @@ func (o *OCIDatasource) resourcegroupsResponse(ctx context.Context, tsdbReq *dat
 
 	var rows_placeholder = common.String("NoResourceGroup")
 
+	var partialErr error
 	for _, query := range tsdbReq.Queries {
 		var ts GrafanaSearchRequest
 		json.Unmarshal([]byte(query.ModelJson), &ts)
@@ -242,10 +851,13 @@ func (o *OCIDatasource) resourcegroupsResponse(ctx context.Context, tsdbReq *dat
 		reqDetails := monitoring.ListMetricsDetails{}
 		reqDetails.Namespace = common.String(ts.Namespace)
 		reqDetails.GroupBy = []string{"resourceGroup"}
-		items, err := o.searchHelper(ctx, ts.Region, ts.Compartment, reqDetails)
-		if err != nil {
+		items, err := o.searchHelperMultiRegion(ctx, clients, ts.TenancyOCID, ts.Region, ts.Compartment, reqDetails)
+		if err != nil && len(items) == 0 {
 			return nil, errors.Wrap(err, fmt.Sprint("list metrircs failed", spew.Sdump(reqDetails)))
 		}
+		if err != nil {
+			partialErr = err
+		}
 
 		rows := make([]*datasource.TableRow, 0)
 		rows = append(rows, &datasource.TableRow{
@@ -268,28 +880,38 @@ func (o *OCIDatasource) resourcegroupsResponse(ctx context.Context, tsdbReq *dat
 		}
 		table.Rows = rows
 	}
+	res := &datasource.QueryResult{
+		RefId:  "resourcegroups",
+		Tables: []*datasource.Table{&table},
+	}
+	if partialErr != nil {
+		res.Error = partialErr.Error()
+	}
 	return &datasource.DatasourceResponse{
-		Results: []*datasource.QueryResult{
-			&datasource.QueryResult{
-				RefId:  "resourcegroups",
-				Tables: []*datasource.Table{&table},
-			},
-		},
+		Results: []*datasource.QueryResult{res},
 	}, nil
 }
 
-func getConfigProvider(environment string) (common.ConfigurationProvider, error) {
+func getConfigProvider(environment, profile string, raw GrafanaCommonRequest) (common.ConfigurationProvider, error) {
 	switch environment {
 	case "local":
 		return common.DefaultConfigProvider(), nil
 	case "OCI Instance":
 		return auth.InstancePrincipalConfigurationProvider()
+	case "OCI Resource Principal":
+		// workload identity for OKE pods and Functions
+		return auth.ResourcePrincipalConfigurationProvider()
+	case "OCI User Principal":
+		return common.CustomProfileConfigProvider("", profile), nil
+	case "OCI Raw":
+		passphrase := raw.PrivateKeyPassphrase
+		return common.NewRawConfigurationProvider(raw.TenancyOCID, raw.UserOCID, raw.Region, raw.Fingerprint, raw.PrivateKey, &passphrase), nil
 	default:
 		return nil, errors.New("unknown environment type")
 	}
 }
 
-func (o *OCIDatasource) searchResponse(ctx context.Context, tsdbReq *datasource.DatasourceRequest) (*datasource.DatasourceResponse, error) {
+func (o *OCIDatasource) searchResponse(ctx context.Context, tsdbReq *datasource.DatasourceRequest, clients *ociClients) (*datasource.DatasourceResponse, error) {
 	table := datasource.Table{
 		Columns: []*datasource.TableColumn{
 			&datasource.TableColumn{Name: "text"},
@@ -297,6 +919,7 @@ func (o *OCIDatasource) searchResponse(ctx context.Context, tsdbReq *datasource.
 		Rows: make([]*datasource.TableRow, 0),
 	}
 
+	var partialErr error
 	for _, query := range tsdbReq.Queries {
 		var ts GrafanaSearchRequest
 		json.Unmarshal([]byte(query.ModelJson), &ts)
@@ -308,10 +931,13 @@ func (o *OCIDatasource) searchResponse(ctx context.Context, tsdbReq *datasource.
 			reqDetails.ResourceGroup = common.String(ts.ResourceGroup)
 		}
 
-		items, err := o.searchHelper(ctx, ts.Region, ts.Compartment, reqDetails)
-		if err != nil {
+		items, err := o.searchHelperMultiRegion(ctx, clients, ts.TenancyOCID, ts.Region, ts.Compartment, reqDetails)
+		if err != nil && len(items) == 0 {
 			return nil, errors.Wrap(err, fmt.Sprint("list metrircs failed", spew.Sdump(reqDetails)))
 		}
+		if err != nil {
+			partialErr = err
+		}
 
 		rows := make([]*datasource.TableRow, 0)
 		metricCache := make(map[string]bool)
@@ -330,35 +956,44 @@ func (o *OCIDatasource) searchResponse(ctx context.Context, tsdbReq *datasource.
 		}
 		table.Rows = rows
 	}
+	res := &datasource.QueryResult{
+		RefId:  "search",
+		Tables: []*datasource.Table{&table},
+	}
+	if partialErr != nil {
+		res.Error = partialErr.Error()
+	}
 	return &datasource.DatasourceResponse{
-		Results: []*datasource.QueryResult{
-			&datasource.QueryResult{
-				RefId:  "search",
-				Tables: []*datasource.Table{&table},
-			},
-		},
+		Results: []*datasource.QueryResult{res},
 	}, nil
 
 }
 
 const MAX_PAGES_TO_FETCH = 20
 
-func (o *OCIDatasource) searchHelper(ctx context.Context, region, compartment string, metricDetails monitoring.ListMetricsDetails) ([]monitoring.Metric, error) {
+func (o *OCIDatasource) searchHelper(ctx context.Context, clients *ociClients, region, compartment string, metricDetails monitoring.ListMetricsDetails) ([]monitoring.Metric, error) {
 	var items []monitoring.Metric
 	var page *string
 
+	client, err := o.metricsClientForRegion(clients, region)
+	if err != nil {
+		return nil, err
+	}
+
 	pageNumber := 0
 	for {
-		reg := common.StringToRegion(region)
-		o.metricsClient.SetRegion(string(reg))
-		res, err := o.metricsClient.ListMetrics(ctx, monitoring.ListMetricsRequest{
+		if err := ctx.Err(); err != nil {
+			return items, errors.Wrap(err, "search cancelled before fetching all pages")
+		}
+
+		res, err := client.ListMetrics(ctx, monitoring.ListMetricsRequest{
 			CompartmentId:      common.String(compartment),
 			ListMetricsDetails: metricDetails,
 			Page:               page,
 		})
 
 		if err != nil {
-			return nil, errors.Wrap(err, "list metrircs failed")
+			return items, errors.Wrap(err, "list metrircs failed")
 		}
 		items = append(items, res.Items...)
 		// Only 0 - n-1  pages are to be fetched, as indexing starts from 0 (for page number
@@ -372,28 +1007,82 @@ func (o *OCIDatasource) searchHelper(ctx context.Context, region, compartment st
 	return items, nil
 }
 
-func (o *OCIDatasource) compartmentsResponse(ctx context.Context, tsdbReq *datasource.DatasourceRequest) (*datasource.DatasourceResponse, error) {
+// searchHelperMultiRegion resolves region (which may be "all" or a
+// comma-separated list, see resolveRegions) and runs searchHelper against
+// each region concurrently, bounded by maxRegionWorkers like queryResponse's
+// region fan-out. Results from every region are merged into one slice; a
+// region that fails contributes only the pages it fetched before failing
+// rather than failing the whole lookup. When regions fail, one of their
+// errors is returned alongside the merged items so the caller can still
+// surface it as a partial error.
+func (o *OCIDatasource) searchHelperMultiRegion(ctx context.Context, clients *ociClients, tenancyOCID, region, compartment string, metricDetails monitoring.ListMetricsDetails) ([]monitoring.Metric, error) {
+	regions, err := o.resolveRegions(ctx, clients, tenancyOCID, region)
+	if err != nil {
+		return nil, err
+	}
+
+	type regionResult struct {
+		items []monitoring.Metric
+		err   error
+	}
+	results := make(chan regionResult, len(regions))
+	sem := make(chan struct{}, maxRegionWorkers)
+	var wg sync.WaitGroup
+	for _, r := range regions {
+		wg.Add(1)
+		go func(r string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			items, err := o.searchHelper(ctx, clients, r, compartment, metricDetails)
+			if err != nil {
+				err = errors.Wrap(err, fmt.Sprintf("region %s", r))
+			}
+			results <- regionResult{items: items, err: err}
+		}(r)
+	}
+	wg.Wait()
+	close(results)
+
+	var items []monitoring.Metric
+	var lastErr error
+	for res := range results {
+		items = append(items, res.items...)
+		if res.err != nil {
+			lastErr = res.err
+		}
+	}
+	return items, lastErr
+}
+
+func (o *OCIDatasource) compartmentsResponse(ctx context.Context, tsdbReq *datasource.DatasourceRequest, clients *ociClients) (*datasource.DatasourceResponse, error) {
 	table := datasource.Table{
 		Columns: []*datasource.TableColumn{
 			&datasource.TableColumn{Name: "text"},
 			&datasource.TableColumn{Name: "text"},
 		},
 	}
-	now := time.Now()
 	var ts GrafanaSearchRequest
 	json.Unmarshal([]byte(tsdbReq.Queries[0].ModelJson), &ts)
-	if o.timeCacheUpdated.IsZero() || now.Sub(o.timeCacheUpdated) > cacheRefreshTime {
 
-		m, err := o.getCompartments(ctx, ts.Region, ts.TenancyOCID)
-		if err != nil {
-			o.logger.Error("Unable to refresh cache")
+	nameToOCID, err := o.compartmentsFor(ctx, clients, ts.Region, ts.TenancyOCID)
+	if err != nil {
+		o.logger.Error("Unable to refresh compartment cache", "region", ts.Region, "tenancy", ts.TenancyOCID, "error", err.Error())
+		// compartmentsFor keeps serving the last-known-good map alongside a
+		// refresh error (see the fetchErr branch in compartmentsFor), so only fail
+		// the query outright if there's truly nothing to show.
+		if len(nameToOCID) == 0 {
 			return nil, err
 		}
-		o.nameToOCID = m
 	}
 
-	rows := make([]*datasource.TableRow, 0, len(o.nameToOCID))
-	for name, id := range o.nameToOCID {
+	res := &datasource.QueryResult{RefId: "compartments"}
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	rows := make([]*datasource.TableRow, 0, len(nameToOCID))
+	for name, id := range nameToOCID {
 		val := &datasource.RowValue{
 			Kind:        datasource.RowValue_TYPE_STRING,
 			StringValue: name,
@@ -411,24 +1100,259 @@ func (o *OCIDatasource) compartmentsResponse(ctx context.Context, tsdbReq *datas
 		})
 	}
 	table.Rows = rows
+	res.Tables = []*datasource.Table{&table}
+	return &datasource.DatasourceResponse{
+		Results: []*datasource.QueryResult{res},
+	}, nil
+}
+
+// cacheStatsResponse reports compartmentCache hit/miss counters and the most
+// recent refresh error, so an operator can tell from inside Grafana whether
+// a data source is hitting the cache or hammering identity on every panel.
+func (o *OCIDatasource) cacheStatsResponse(ctx context.Context, tsdbReq *datasource.DatasourceRequest) (*datasource.DatasourceResponse, error) {
+	hits, misses, entries, lastErr := o.compartmentCache.stats()
+
+	lastErrText := ""
+	if lastErr != nil {
+		lastErrText = lastErr.Error()
+	}
+
+	table := datasource.Table{
+		Columns: []*datasource.TableColumn{
+			&datasource.TableColumn{Name: "stat"},
+			&datasource.TableColumn{Name: "value"},
+		},
+		Rows: []*datasource.TableRow{
+			stringTableRow("hits", fmt.Sprint(hits)),
+			stringTableRow("misses", fmt.Sprint(misses)),
+			stringTableRow("cached_entries", fmt.Sprint(entries)),
+			stringTableRow("last_error", lastErrText),
+		},
+	}
 	return &datasource.DatasourceResponse{
 		Results: []*datasource.QueryResult{
 			&datasource.QueryResult{
-				RefId:  "compartments",
+				RefId:  "cachestats",
 				Tables: []*datasource.Table{&table},
 			},
 		},
 	}, nil
 }
 
-func (o *OCIDatasource) getCompartments(ctx context.Context, region string, rootCompartment string) (map[string]string, error) {
+// auditLogResponse returns clients' identity's audit ring buffer contents as
+// a datasource.Table, newest entries last, so an operator can see exactly
+// which OCI API calls that data source has been making without shell access
+// to the plugin host.
+func (o *OCIDatasource) auditLogResponse(ctx context.Context, tsdbReq *datasource.DatasourceRequest, clients *ociClients) (*datasource.DatasourceResponse, error) {
+	records := o.auditScopeFor(clients.key).ring.snapshot()
+
+	table := datasource.Table{
+		Columns: []*datasource.TableColumn{
+			&datasource.TableColumn{Name: "time"},
+			&datasource.TableColumn{Name: "caller"},
+			&datasource.TableColumn{Name: "region"},
+			&datasource.TableColumn{Name: "compartment"},
+			&datasource.TableColumn{Name: "request"},
+			&datasource.TableColumn{Name: "status"},
+			&datasource.TableColumn{Name: "opc-request-id"},
+			&datasource.TableColumn{Name: "latency_ms"},
+			&datasource.TableColumn{Name: "bytes"},
+		},
+		Rows: make([]*datasource.TableRow, 0, len(records)),
+	}
+	for _, rec := range records {
+		table.Rows = append(table.Rows, stringTableRow(
+			rec.Time.Format(time.RFC3339),
+			rec.Caller,
+			rec.Region,
+			rec.Compartment,
+			rec.RequestKind,
+			fmt.Sprint(rec.StatusCode),
+			rec.OpcRequestID,
+			fmt.Sprint(rec.Latency.Milliseconds()),
+			fmt.Sprint(rec.Bytes),
+		))
+	}
+
+	return &datasource.DatasourceResponse{
+		Results: []*datasource.QueryResult{
+			&datasource.QueryResult{
+				RefId:  "auditlog",
+				Tables: []*datasource.Table{&table},
+			},
+		},
+	}, nil
+}
+
+// stringTableRow builds a datasource.TableRow of string-typed cells.
+func stringTableRow(values ...string) *datasource.TableRow {
+	row := &datasource.TableRow{Values: make([]*datasource.RowValue, 0, len(values))}
+	for _, v := range values {
+		row.Values = append(row.Values, &datasource.RowValue{Kind: datasource.RowValue_TYPE_STRING, StringValue: v})
+	}
+	return row
+}
+
+const (
+	// compartmentNegativeCacheTTL bounds how long a failed compartment
+	// lookup (e.g. a tenancy IAM doesn't have access to) is cached before
+	// being retried, so a broken data source doesn't hammer IAM on every
+	// panel load.
+	compartmentNegativeCacheTTL = 2 * time.Minute
+	// compartmentBackgroundRefreshWindow is how recently an entry must have
+	// been accessed for the background refresher to keep it warm.
+	compartmentBackgroundRefreshWindow = 10 * time.Minute
+)
+
+type compartmentCacheKey struct {
+	client  clientKey
+	tenancy string
+	region  string
+}
+
+type compartmentCacheEntry struct {
+	nameToOCID map[string]string
+	updatedAt  time.Time
+	err        error
+	errAt      time.Time
+	lastAccess time.Time
+	// clients is the identity that populated this entry, kept so a
+	// background refresh can reuse the same identity instead of whichever
+	// one happens to be active elsewhere.
+	clients *ociClients
+}
+
+// compartmentCache is a concurrency-safe, TTL-based cache of the
+// fully-qualified-name -> OCID map built by getCompartments for a
+// (client, tenancy, region) triple. Keying on the client as well as the
+// tenancy/region keeps two identities that happen to share a tenancy OCID
+// (e.g. distinct raw-credential datasources) from reading or refreshing
+// each other's cache entry. Concurrent callers for the same key coalesce
+// onto a single in-flight refresh, and a refresh that errors is cached
+// negatively for compartmentNegativeCacheTTL instead of being retried on
+// every caller.
+type compartmentCache struct {
+	mu       sync.RWMutex
+	entries  map[compartmentCacheKey]*compartmentCacheEntry
+	inFlight map[compartmentCacheKey]chan struct{}
+	hits     int64
+	misses   int64
+	lastErr  error
+}
+
+func newCompartmentCache() *compartmentCache {
+	return &compartmentCache{
+		entries:  make(map[compartmentCacheKey]*compartmentCacheEntry),
+		inFlight: make(map[compartmentCacheKey]chan struct{}),
+	}
+}
+
+func (c *compartmentCache) stats() (hits, misses int64, entries int, lastErr error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.hits, c.misses, len(c.entries), c.lastErr
+}
+
+// compartmentsFor returns the cached compartment map for (tenancyOCID,
+// region), refreshing it via o.getCompartments if it is missing, stale, or
+// past its negative-cache window. Concurrent calls for the same key share
+// one refresh.
+func (o *OCIDatasource) compartmentsFor(ctx context.Context, clients *ociClients, region, tenancyOCID string) (map[string]string, error) {
+	key := compartmentCacheKey{client: clients.key, tenancy: tenancyOCID, region: region}
+	c := o.compartmentCache
+
+	c.mu.Lock()
+	now := time.Now()
+	if entry, ok := c.entries[key]; ok {
+		entry.lastAccess = now
+		fresh := entry.err == nil && now.Sub(entry.updatedAt) < cacheRefreshTime
+		negativelyCached := entry.err != nil && now.Sub(entry.errAt) < compartmentNegativeCacheTTL
+		if fresh || negativelyCached {
+			c.hits++
+			nameToOCID, err := entry.nameToOCID, entry.err
+			c.mu.Unlock()
+			return nameToOCID, err
+		}
+	}
+
+	if wait, inFlight := c.inFlight[key]; inFlight {
+		c.mu.Unlock()
+		<-wait
+		return o.compartmentsFor(ctx, clients, region, tenancyOCID)
+	}
+	c.misses++
+	wait := make(chan struct{})
+	c.inFlight[key] = wait
+	c.mu.Unlock()
+
+	nameToOCID, fetchErr := o.getCompartments(ctx, clients.identityClient, region, tenancyOCID)
+
+	c.mu.Lock()
+	entry := &compartmentCacheEntry{lastAccess: now, clients: clients}
+	if fetchErr != nil {
+		entry.err = fetchErr
+		entry.errAt = time.Now()
+		// keep serving the last-known-good map on a transient failure
+		if prior, ok := c.entries[key]; ok {
+			entry.nameToOCID = prior.nameToOCID
+		}
+	} else {
+		entry.nameToOCID = nameToOCID
+		entry.updatedAt = time.Now()
+	}
+	c.entries[key] = entry
+	c.lastErr = fetchErr
+	delete(c.inFlight, key)
+	c.mu.Unlock()
+	close(wait)
+
+	return entry.nameToOCID, fetchErr
+}
+
+// startCompartmentCacheRefresher periodically re-warms compartment cache
+// entries that have been accessed recently, so a busy dashboard's next
+// panel load finds a fresh cache entry instead of stalling behind a
+// synchronous getCompartments call. Each entry is refreshed with the same
+// *ociClients that originally populated it, rather than any single
+// "currently active" client, so a data source serving several identities
+// doesn't refresh one tenant's compartments with another's credentials.
+func (o *OCIDatasource) startCompartmentCacheRefresher() {
+	go func() {
+		ticker := time.NewTicker(cacheRefreshTime)
+		defer ticker.Stop()
+		for range ticker.C {
+			now := time.Now()
+			c := o.compartmentCache
+			c.mu.RLock()
+			type refreshTarget struct {
+				key     compartmentCacheKey
+				clients *ociClients
+			}
+			targets := make([]refreshTarget, 0, len(c.entries))
+			for key, entry := range c.entries {
+				if now.Sub(entry.lastAccess) <= compartmentBackgroundRefreshWindow {
+					targets = append(targets, refreshTarget{key: key, clients: entry.clients})
+				}
+			}
+			c.mu.RUnlock()
+
+			for _, t := range targets {
+				if _, err := o.compartmentsFor(context.Background(), t.clients, t.key.region, t.key.tenancy); err != nil {
+					o.logger.Error("background compartment cache refresh failed", "tenancy", t.key.tenancy, "region", t.key.region, "error", err.Error())
+				}
+			}
+		}
+	}()
+}
+
+func (o *OCIDatasource) getCompartments(ctx context.Context, identityClient IdentityAPI, region string, rootCompartment string) (map[string]string, error) {
 	m := make(map[string]string)
 
 	tenancyOcid := rootCompartment
 
 	req := identity.GetTenancyRequest{TenancyId: common.String(tenancyOcid)}
 	// Send the request using the service client
-	resp, err := o.identityClient.GetTenancy(context.Background(), req)
+	resp, err := identityClient.GetTenancy(ctx, req)
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("This is what we were trying to get %s", " : fetching tenancy name"))
 	}
@@ -441,9 +1365,13 @@ func (o *OCIDatasource) getCompartments(ctx context.Context, region string, root
 
 	var page *string
 	reg := common.StringToRegion(region)
-	o.identityClient.SetRegion(string(reg))
+	identityClient.SetRegion(string(reg))
 	for {
-		res, err := o.identityClient.ListCompartments(ctx,
+		if err := ctx.Err(); err != nil {
+			return nil, errors.Wrap(err, "getCompartments cancelled before fetching all pages")
+		}
+
+		res, err := identityClient.ListCompartments(ctx,
 			identity.ListCompartmentsRequest{
 				CompartmentId:          &rootCompartment,
 				Page:                   page,
@@ -493,14 +1421,16 @@ func (o *OCIDatasource) getCompartments(ctx context.Context, region string, root
 	return m, nil
 }
 
-type responseAndQuery struct {
+// regionQueryResult is the outcome of running SummarizeMetricsData against a
+// single region as part of a (possibly multi-region) query.
+type regionQueryResult struct {
+	region string
 	ociRes monitoring.SummarizeMetricsDataResponse
-	query  *datasource.Query
 	err    error
 }
 
-func (o *OCIDatasource) queryResponse(ctx context.Context, tsdbReq *datasource.DatasourceRequest) (*datasource.DatasourceResponse, error) {
-	results := make([]responseAndQuery, 0, len(tsdbReq.Queries))
+func (o *OCIDatasource) queryResponse(ctx context.Context, tsdbReq *datasource.DatasourceRequest, clients *ociClients) (*datasource.DatasourceResponse, error) {
+	queryRes := make([]*datasource.QueryResult, 0, len(tsdbReq.Queries))
 
 	for _, query := range tsdbReq.Queries {
 		var ts GrafanaOCIRequest
@@ -522,77 +1452,113 @@ func (o *OCIDatasource) queryResponse(ctx context.Context, tsdbReq *datasource.D
 			req.ResourceGroup = common.String(ts.ResourceGroup)
 		}
 
-		reg := common.StringToRegion(ts.Region)
-		o.metricsClient.SetRegion(string(reg))
-
-		request := monitoring.SummarizeMetricsDataRequest{
-			CompartmentId:               common.String(ts.Compartment),
-			SummarizeMetricsDataDetails: req,
-		}
+		res := &datasource.QueryResult{RefId: query.RefId}
 
-		res, err := o.metricsClient.SummarizeMetricsData(ctx, request)
+		regions, err := o.resolveRegions(ctx, clients, ts.TenancyOCID, ts.Region)
 		if err != nil {
-			return nil, errors.Wrap(err, fmt.Sprint(spew.Sdump(query), spew.Sdump(request), spew.Sdump(res)))
-		}
-		results = append(results, responseAndQuery{
-			res,
-			query,
-			err,
-		})
-	}
-	queryRes := make([]*datasource.QueryResult, 0, len(results))
-	for _, q := range results {
-		res := &datasource.QueryResult{
-			RefId: q.query.RefId,
-		}
-		if q.err != nil {
-			res.Error = q.err.Error()
+			res.Error = err.Error()
 			queryRes = append(queryRes, res)
 			continue
 		}
-		//Items -> timeserries
-		series := make([]*datasource.TimeSeries, 0, len(q.ociRes.Items))
-		for _, item := range q.ociRes.Items {
-			t := &datasource.TimeSeries{
-				Name: *(item.Name),
-			}
-			var re = regexp.MustCompile(`(?m)\w+Name`)
-			dimensionKeys := make([]string, len(item.Dimensions))
-			i := 0
 
-			for key, dimension := range item.Dimensions {
-				if re.MatchString(key) {
-					t.Name = fmt.Sprintf("%s, {%s}", t.Name, dimension)
+		results := make(chan regionQueryResult, len(regions))
+		sem := make(chan struct{}, maxRegionWorkers)
+		var wg sync.WaitGroup
+		for _, region := range regions {
+			wg.Add(1)
+			go func(region string) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				stop := whenCancelled(ctx, func() {
+					o.logger.Warn("query cancelled before region finished", "region", region)
+				})
+				defer stop()
+
+				if err := ctx.Err(); err != nil {
+					results <- regionQueryResult{region: region, err: err}
+					return
+				}
+
+				client, err := o.metricsClientForRegion(clients, region)
+				if err != nil {
+					results <- regionQueryResult{region: region, err: err}
+					return
 				}
-				dimensionKeys[i] = key
-				i++
+
+				request := monitoring.SummarizeMetricsDataRequest{
+					CompartmentId:               common.String(ts.Compartment),
+					SummarizeMetricsDataDetails: req,
+				}
+				ociRes, err := client.SummarizeMetricsData(ctx, request)
+				if err != nil {
+					err = errors.Wrap(err, fmt.Sprintf("summarize metrics data failed for region %s, compartment %s", region, ts.Compartment))
+				}
+				results <- regionQueryResult{region: region, ociRes: ociRes, err: err}
+			}(region)
+		}
+		wg.Wait()
+		close(results)
+
+		// Items -> timeserries. A region's failure becomes a series-level
+		// placeholder rather than failing the whole query, so a slow or
+		// broken region doesn't take down every other region's panel data.
+		series := make([]*datasource.TimeSeries, 0)
+		for r := range results {
+			if r.err != nil {
+				o.logger.Error("region query failed", "region", r.region, "error", r.err.Error())
+				series = append(series, &datasource.TimeSeries{
+					Name: fmt.Sprintf("error, {region=%s}: %s", r.region, r.err.Error()),
+				})
+				continue
 			}
+			for _, item := range r.ociRes.Items {
+				t := &datasource.TimeSeries{
+					Name: *(item.Name),
+				}
+				var re = regexp.MustCompile(`(?m)\w+Name`)
+				dimensionKeys := make([]string, len(item.Dimensions))
+				i := 0
 
-			// if there isn't a human readable name fallback to resourceId
-			if t.Name == *(item).Name {
-				var preDisplayName string = ""
-				sort.Strings(dimensionKeys)
-				for _, dimensionKey := range dimensionKeys {
-					if preDisplayName == "" {
-						preDisplayName = item.Dimensions[dimensionKey]
-					} else {
-						preDisplayName = preDisplayName + ", " + item.Dimensions[dimensionKey]
+				for key, dimension := range item.Dimensions {
+					if re.MatchString(key) {
+						t.Name = fmt.Sprintf("%s, {%s}", t.Name, dimension)
 					}
+					dimensionKeys[i] = key
+					i++
 				}
 
-				t.Name = fmt.Sprintf("%s, {%s}", t.Name, preDisplayName)
-			}
+				// if there isn't a human readable name fallback to resourceId
+				if t.Name == *(item).Name {
+					var preDisplayName string = ""
+					sort.Strings(dimensionKeys)
+					for _, dimensionKey := range dimensionKeys {
+						if preDisplayName == "" {
+							preDisplayName = item.Dimensions[dimensionKey]
+						} else {
+							preDisplayName = preDisplayName + ", " + item.Dimensions[dimensionKey]
+						}
+					}
+
+					t.Name = fmt.Sprintf("%s, {%s}", t.Name, preDisplayName)
+				}
+
+				if len(regions) > 1 {
+					t.Name = fmt.Sprintf("%s, {region=%s}", t.Name, r.region)
+				}
 
-			p := make([]*datasource.Point, 0, len(item.AggregatedDatapoints))
-			for _, metric := range item.AggregatedDatapoints {
-				point := &datasource.Point{
-					Timestamp: int64(metric.Timestamp.UnixNano() / 1000000),
-					Value:     *(metric.Value),
+				p := make([]*datasource.Point, 0, len(item.AggregatedDatapoints))
+				for _, metric := range item.AggregatedDatapoints {
+					point := &datasource.Point{
+						Timestamp: int64(metric.Timestamp.UnixNano() / 1000000),
+						Value:     *(metric.Value),
+					}
+					p = append(p, point)
 				}
-				p = append(p, point)
+				t.Points = p
+				series = append(series, t)
 			}
-			t.Points = p
-			series = append(series, t)
 		}
 		res.Series = series
 		queryRes = append(queryRes, res)
@@ -605,7 +1571,7 @@ func (o *OCIDatasource) queryResponse(ctx context.Context, tsdbReq *datasource.D
 	return response, nil
 }
 
-func (o *OCIDatasource) regionsResponse(ctx context.Context, tsdbReq *datasource.DatasourceRequest) (*datasource.DatasourceResponse, error) {
+func (o *OCIDatasource) regionsResponse(ctx context.Context, tsdbReq *datasource.DatasourceRequest, clients *ociClients) (*datasource.DatasourceResponse, error) {
 	table := datasource.Table{
 		Columns: []*datasource.TableColumn{
 			&datasource.TableColumn{Name: "text"},
@@ -615,7 +1581,7 @@ func (o *OCIDatasource) regionsResponse(ctx context.Context, tsdbReq *datasource
 	for _, query := range tsdbReq.Queries {
 		var ts GrafanaOCIRequest
 		json.Unmarshal([]byte(query.ModelJson), &ts)
-		res, err := o.identityClient.ListRegions(ctx)
+		res, err := clients.identityClient.ListRegions(ctx)
 		if err != nil {
 			return nil, errors.Wrap(err, "error fetching regions")
 		}
@@ -641,3 +1607,190 @@ func (o *OCIDatasource) regionsResponse(ctx context.Context, tsdbReq *datasource
 		},
 	}, nil
 }
+
+// warnUnsupportedAlarmFilters logs when an alarms/alarmhistory query asked to
+// filter by namespace or resourceGroup: AlarmStatusSummary doesn't carry
+// either field (see alarmsHelper), so the filter is silently ignored and the
+// query gets back every alarm in the compartment. Without this, that looks
+// like a working filter that mysteriously stopped filtering.
+func (o *OCIDatasource) warnUnsupportedAlarmFilters(namespace, resourceGroup string) {
+	if namespace != "" {
+		o.logger.Warn("alarms query requested a namespace filter the Monitoring alarm status API can't apply; returning unfiltered results", "namespace", namespace)
+	}
+	if resourceGroup != "" && resourceGroup != "NoResourceGroup" {
+		o.logger.Warn("alarms query requested a resourceGroup filter the Monitoring alarm status API can't apply; returning unfiltered results", "resourceGroup", resourceGroup)
+	}
+}
+
+// alarmsHelper pages through ListAlarmsStatus for compartment/region,
+// mirroring searchHelper's pagination (capped at MAX_PAGES_TO_FETCH).
+//
+// monitoring.AlarmStatusSummary (unlike the alarm resource itself) carries
+// only Id/DisplayName/Severity/TimestampTriggered/Status/Suppression - no
+// Namespace, ResourceGroup or Dimensions - so there's nothing here to filter
+// or group by those fields client-side. A Namespace/ResourceGroup filter
+// would need a GetAlarm/ListAlarms call per alarm to pull that detail in.
+// See warnUnsupportedAlarmFilters for the operator-visible signal that a
+// configured filter isn't being applied.
+func (o *OCIDatasource) alarmsHelper(ctx context.Context, clients *ociClients, region, compartment string) ([]monitoring.AlarmStatusSummary, error) {
+	var items []monitoring.AlarmStatusSummary
+	var page *string
+
+	client, err := o.metricsClientForRegion(clients, region)
+	if err != nil {
+		return nil, err
+	}
+
+	pageNumber := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return items, errors.Wrap(err, "list alarms status cancelled before fetching all pages")
+		}
+
+		res, err := client.ListAlarmsStatus(ctx, monitoring.ListAlarmsStatusRequest{
+			CompartmentId: common.String(compartment),
+			Page:          page,
+		})
+		if err != nil {
+			return items, errors.Wrap(err, "list alarms status failed")
+		}
+		items = append(items, res.Items...)
+		if res.OpcNextPage == nil || pageNumber >= MAX_PAGES_TO_FETCH {
+			break
+		}
+		page = res.OpcNextPage
+		pageNumber++
+	}
+	return items, nil
+}
+
+func (o *OCIDatasource) alarmsResponse(ctx context.Context, tsdbReq *datasource.DatasourceRequest, clients *ociClients) (*datasource.DatasourceResponse, error) {
+	table := datasource.Table{
+		Columns: []*datasource.TableColumn{
+			&datasource.TableColumn{Name: "name"},
+			&datasource.TableColumn{Name: "severity"},
+			&datasource.TableColumn{Name: "state"},
+			&datasource.TableColumn{Name: "timestamp"},
+		},
+		Rows: make([]*datasource.TableRow, 0),
+	}
+
+	var partialErr error
+	for _, query := range tsdbReq.Queries {
+		var ts GrafanaSearchRequest
+		json.Unmarshal([]byte(query.ModelJson), &ts)
+		o.warnUnsupportedAlarmFilters(ts.Namespace, ts.ResourceGroup)
+
+		items, err := o.alarmsHelper(ctx, clients, ts.Region, ts.Compartment)
+		if err != nil && len(items) == 0 {
+			return nil, errors.Wrap(err, "list alarms status failed")
+		}
+		if err != nil {
+			partialErr = err
+		}
+
+		rows := make([]*datasource.TableRow, 0, len(items))
+		for _, item := range items {
+			timestamp := ""
+			if item.TimestampTriggered != nil {
+				timestamp = item.TimestampTriggered.String()
+			}
+
+			rows = append(rows, stringTableRow(
+				*item.DisplayName,
+				string(item.Severity),
+				string(item.Status),
+				timestamp,
+			))
+		}
+		table.Rows = rows
+	}
+	res := &datasource.QueryResult{
+		RefId:  "alarms",
+		Tables: []*datasource.Table{&table},
+	}
+	if partialErr != nil {
+		res.Error = partialErr.Error()
+	}
+	return &datasource.DatasourceResponse{
+		Results: []*datasource.QueryResult{res},
+	}, nil
+}
+
+// alarmStateValue maps an alarm history entry's summary text to the step
+// value Grafana's state-timeline panel expects: 0=OK, 1=FIRING, 2=SUSPENDED.
+//
+// AlarmHistoryEntry.Summary isn't a stable, documented contract - the SDK's
+// own comment for it warns "Avoid entering confidential information" rather
+// than specifying an exact format - and in practice it varies: a state
+// transition reads "State transitioned from OK to Firing" (title case)
+// while the non-transition history reads all-caps "FIRING"/"SUSPENDED". The
+// match is case-insensitive to cover both, and for a transition only the
+// state after the last " to " is matched.
+func alarmStateValue(summary *string) float64 {
+	if summary == nil {
+		return 0
+	}
+	text := strings.ToUpper(*summary)
+	if i := strings.LastIndex(text, " TO "); i >= 0 {
+		text = text[i+len(" TO "):]
+	}
+	switch {
+	case strings.Contains(text, "FIRING"):
+		return 1
+	case strings.Contains(text, "SUSPEND"):
+		return 2
+	default:
+		return 0
+	}
+}
+
+func (o *OCIDatasource) alarmHistoryResponse(ctx context.Context, tsdbReq *datasource.DatasourceRequest, clients *ociClients) (*datasource.DatasourceResponse, error) {
+	queryRes := make([]*datasource.QueryResult, 0, len(tsdbReq.Queries))
+
+	for _, query := range tsdbReq.Queries {
+		var ts GrafanaSearchRequest
+		json.Unmarshal([]byte(query.ModelJson), &ts)
+		o.warnUnsupportedAlarmFilters(ts.Namespace, ts.ResourceGroup)
+
+		client, err := o.metricsClientForRegion(clients, ts.Region)
+		if err != nil {
+			return nil, err
+		}
+
+		alarms, err := o.alarmsHelper(ctx, clients, ts.Region, ts.Compartment)
+		if err != nil {
+			return nil, err
+		}
+
+		res := &datasource.QueryResult{RefId: query.RefId}
+		var partialErr error
+		series := make([]*datasource.TimeSeries, 0, len(alarms))
+		for _, alarm := range alarms {
+			history, err := client.GetAlarmHistory(ctx, monitoring.GetAlarmHistoryRequest{AlarmId: alarm.Id})
+			if err != nil {
+				o.logger.Error("get alarm history failed", "alarm", *alarm.DisplayName, "error", err.Error())
+				partialErr = err
+				continue
+			}
+
+			t := &datasource.TimeSeries{Name: *alarm.DisplayName}
+			points := make([]*datasource.Point, 0, len(history.Entries))
+			for _, entry := range history.Entries {
+				points = append(points, &datasource.Point{
+					Timestamp: int64(entry.Timestamp.UnixNano() / 1000000),
+					Value:     alarmStateValue(entry.Summary),
+				})
+			}
+			t.Points = points
+			series = append(series, t)
+		}
+		res.Series = series
+		if partialErr != nil {
+			res.Error = partialErr.Error()
+		}
+		queryRes = append(queryRes, res)
+	}
+
+	return &datasource.DatasourceResponse{Results: queryRes}, nil
+}
@@ -0,0 +1,287 @@
+// Copyright © 2019 Oracle and/or its affiliates. All rights reserved.
+// Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl.
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana_plugin_model/go/datasource"
+	"github.com/hashicorp/go-hclog"
+	"github.com/oracle/oci-go-sdk/common"
+	"github.com/oracle/oci-go-sdk/identity"
+	"github.com/oracle/oci-go-sdk/monitoring"
+)
+
+// fakeIdentityClient is a minimal IdentityAPI stub. Only the methods a given
+// test actually exercises need real behaviour; the rest panic if called, so
+// an unexpected call fails loudly instead of returning a misleading zero
+// value.
+type fakeIdentityClient struct {
+	tenancyErr      error
+	listCmptErr     error
+	regionSubs      []identity.RegionSubscription
+	regionSubsErr   error
+	listCmptCalls   int32
+	getTenancyCalls int32
+}
+
+func (f *fakeIdentityClient) SetRegion(string) {}
+
+func (f *fakeIdentityClient) GetTenancy(ctx context.Context, req identity.GetTenancyRequest) (identity.GetTenancyResponse, error) {
+	atomic.AddInt32(&f.getTenancyCalls, 1)
+	if f.tenancyErr != nil {
+		return identity.GetTenancyResponse{}, f.tenancyErr
+	}
+	return identity.GetTenancyResponse{
+		Tenancy: identity.Tenancy{Name: common.String("test-tenancy")},
+	}, nil
+}
+
+func (f *fakeIdentityClient) ListCompartments(ctx context.Context, req identity.ListCompartmentsRequest) (identity.ListCompartmentsResponse, error) {
+	atomic.AddInt32(&f.listCmptCalls, 1)
+	if f.listCmptErr != nil {
+		return identity.ListCompartmentsResponse{}, f.listCmptErr
+	}
+	return identity.ListCompartmentsResponse{
+		Items: []identity.Compartment{
+			{
+				Id:             common.String("ocid1.compartment.oc1..a"),
+				Name:           common.String("team-a"),
+				CompartmentId:  req.CompartmentId,
+				LifecycleState: identity.CompartmentLifecycleStateActive,
+			},
+		},
+	}, nil
+}
+
+func (f *fakeIdentityClient) ListRegions(ctx context.Context) (identity.ListRegionsResponse, error) {
+	panic("not implemented")
+}
+
+func (f *fakeIdentityClient) ListRegionSubscriptions(ctx context.Context, req identity.ListRegionSubscriptionsRequest) (identity.ListRegionSubscriptionsResponse, error) {
+	if f.regionSubsErr != nil {
+		return identity.ListRegionSubscriptionsResponse{}, f.regionSubsErr
+	}
+	return identity.ListRegionSubscriptionsResponse{Items: f.regionSubs}, nil
+}
+
+func newTestClients(t *testing.T, identityClient IdentityAPI) *ociClients {
+	t.Helper()
+	key := clientKey{environment: "local", tenancy: "ocid1.tenancy.oc1..a"}
+	return &ociClients{key: key, identityClient: identityClient}
+}
+
+func TestCompartmentCache_SingleFlightAndTTL(t *testing.T) {
+	o := &OCIDatasource{compartmentCache: newCompartmentCache(), logger: hclog.NewNullLogger()}
+	fake := &fakeIdentityClient{}
+	clients := newTestClients(t, fake)
+
+	const concurrency = 10
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			if _, err := o.compartmentsFor(context.Background(), clients, "us-phoenix-1", clients.key.tenancy); err != nil {
+				t.Errorf("compartmentsFor: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&fake.getTenancyCalls); got != 1 {
+		t.Errorf("getTenancyCalls = %d, want 1 (concurrent callers should coalesce onto one refresh)", got)
+	}
+
+	if _, err := o.compartmentsFor(context.Background(), clients, "us-phoenix-1", clients.key.tenancy); err != nil {
+		t.Fatalf("compartmentsFor (cache hit): %v", err)
+	}
+	if got := atomic.LoadInt32(&fake.getTenancyCalls); got != 1 {
+		t.Errorf("getTenancyCalls = %d after a fresh cache hit, want 1 (should not refetch)", got)
+	}
+
+	hits, misses, entries, _ := o.compartmentCache.stats()
+	if hits == 0 {
+		t.Error("expected at least one cache hit to be recorded")
+	}
+	if misses != 1 {
+		t.Errorf("misses = %d, want 1", misses)
+	}
+	if entries != 1 {
+		t.Errorf("entries = %d, want 1", entries)
+	}
+}
+
+func TestCompartmentCache_NegativeCaching(t *testing.T) {
+	o := &OCIDatasource{compartmentCache: newCompartmentCache(), logger: hclog.NewNullLogger()}
+	fake := &fakeIdentityClient{tenancyErr: errNotAuthorized}
+	clients := newTestClients(t, fake)
+
+	if _, err := o.compartmentsFor(context.Background(), clients, "us-phoenix-1", clients.key.tenancy); err == nil {
+		t.Fatal("expected an error from the first, failing fetch")
+	}
+	if _, err := o.compartmentsFor(context.Background(), clients, "us-phoenix-1", clients.key.tenancy); err == nil {
+		t.Fatal("expected the negatively-cached error to be returned again")
+	}
+	if got := atomic.LoadInt32(&fake.getTenancyCalls); got != 1 {
+		t.Errorf("getTenancyCalls = %d, want 1 (a failing tenancy should be negatively cached, not retried every call)", got)
+	}
+
+	// Force the negative-cache entry to look expired and confirm it's retried.
+	key := compartmentCacheKey{client: clients.key, tenancy: clients.key.tenancy, region: "us-phoenix-1"}
+	o.compartmentCache.mu.Lock()
+	o.compartmentCache.entries[key].errAt = time.Now().Add(-compartmentNegativeCacheTTL - time.Second)
+	o.compartmentCache.mu.Unlock()
+
+	fake.tenancyErr = nil
+	if _, err := o.compartmentsFor(context.Background(), clients, "us-phoenix-1", clients.key.tenancy); err != nil {
+		t.Fatalf("compartmentsFor after negative-cache TTL expiry: %v", err)
+	}
+	if got := atomic.LoadInt32(&fake.getTenancyCalls); got != 2 {
+		t.Errorf("getTenancyCalls = %d, want 2 (should retry once the negative-cache TTL has elapsed)", got)
+	}
+}
+
+// errNotAuthorized stands in for the auth error IAM returns for a tenancy a
+// data source isn't permitted to read compartments from.
+var errNotAuthorized = &testError{"not authorized"}
+
+type testError struct{ msg string }
+
+func (e *testError) Error() string { return e.msg }
+
+func TestResolveRegions(t *testing.T) {
+	o := &OCIDatasource{}
+	fake := &fakeIdentityClient{regionSubs: []identity.RegionSubscription{
+		{RegionName: common.String("us-phoenix-1")},
+		{RegionName: common.String("us-ashburn-1")},
+	}}
+	clients := newTestClients(t, fake)
+
+	cases := []struct {
+		name    string
+		region  string
+		want    []string
+		wantErr bool
+	}{
+		{name: "single region", region: "us-phoenix-1", want: []string{"us-phoenix-1"}},
+		{name: "comma separated, trims whitespace", region: "us-phoenix-1, us-ashburn-1", want: []string{"us-phoenix-1", "us-ashburn-1"}},
+		{name: "all, case insensitive", region: "ALL", want: []string{"us-phoenix-1", "us-ashburn-1"}},
+		{name: "blank region is an error, not an empty fan-out", region: "", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := o.resolveRegions(context.Background(), clients, clients.key.tenancy, tc.region)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveRegions: %v", err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("resolveRegions(%q) = %v, want %v", tc.region, got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("resolveRegions(%q) = %v, want %v", tc.region, got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	o := &OCIDatasource{}
+
+	t.Run("override always wins", func(t *testing.T) {
+		o := &OCIDatasource{queryTimeoutOverride: 5 * time.Second}
+		if got := o.queryTimeout(&datasource.DatasourceRequest{}); got != 5*time.Second {
+			t.Errorf("queryTimeout = %v, want 5s override", got)
+		}
+	})
+
+	t.Run("no queries falls back to default", func(t *testing.T) {
+		if got := o.queryTimeout(&datasource.DatasourceRequest{}); got != defaultQueryTimeout {
+			t.Errorf("queryTimeout = %v, want %v", got, defaultQueryTimeout)
+		}
+	})
+
+	t.Run("small panel floors at the default", func(t *testing.T) {
+		req := &datasource.DatasourceRequest{Queries: []*datasource.Query{{IntervalMs: 1000, MaxDataPoints: 10}}}
+		if got := o.queryTimeout(req); got != defaultQueryTimeout {
+			t.Errorf("queryTimeout = %v, want floor of %v", got, defaultQueryTimeout)
+		}
+	})
+
+	t.Run("large panel derives a longer deadline, capped", func(t *testing.T) {
+		req := &datasource.DatasourceRequest{Queries: []*datasource.Query{{IntervalMs: 60000, MaxDataPoints: 1000}}}
+		got := o.queryTimeout(req)
+		if got <= defaultQueryTimeout {
+			t.Errorf("queryTimeout = %v, want it to exceed the %v floor for a large panel", got, defaultQueryTimeout)
+		}
+		if got > maxQueryTimeout {
+			t.Errorf("queryTimeout = %v, want it capped at %v", got, maxQueryTimeout)
+		}
+	})
+}
+
+func TestSearchHelper_HonoursCancellation(t *testing.T) {
+	client := &cancellingMetricsClient{}
+	o := &OCIDatasource{regionClients: map[regionClientKey]MetricsAPI{{region: "us-phoenix-1"}: client}}
+	clients := &ociClients{key: clientKey{}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	items, err := o.searchHelper(ctx, clients, "us-phoenix-1", "ocid1.compartment.oc1..a", monitoring.ListMetricsDetails{})
+	if err == nil {
+		t.Fatal("expected searchHelper to report the cancelled context")
+	}
+	if len(items) != 0 {
+		t.Errorf("got %d items, want none: searchHelper should check ctx before issuing any page request", len(items))
+	}
+	if client.calls != 0 {
+		t.Errorf("ListMetrics called %d times, want 0 once ctx is already cancelled", client.calls)
+	}
+}
+
+// cancellingMetricsClient counts ListMetrics calls so tests can assert
+// searchHelper stops issuing requests once its context is done.
+type cancellingMetricsClient struct {
+	MetricsAPI
+	calls int
+}
+
+func (c *cancellingMetricsClient) ListMetrics(ctx context.Context, req monitoring.ListMetricsRequest) (monitoring.ListMetricsResponse, error) {
+	c.calls++
+	return monitoring.ListMetricsResponse{}, nil
+}
+
+func TestAlarmStateValue(t *testing.T) {
+	cases := []struct {
+		name    string
+		summary *string
+		want    float64
+	}{
+		{name: "nil summary", summary: nil, want: 0},
+		{name: "all-caps history format", summary: common.String("FIRING"), want: 1},
+		{name: "title-case transition format", summary: common.String("State transitioned from OK to Firing"), want: 1},
+		{name: "all-caps suspended", summary: common.String("SUSPENDED"), want: 2},
+		{name: "title-case transition to suspended", summary: common.String("State transitioned from OK to Suspended"), want: 2},
+		{name: "OK summary falls through to 0", summary: common.String("OK"), want: 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := alarmStateValue(tc.summary); got != tc.want {
+				t.Errorf("alarmStateValue(%v) = %v, want %v", tc.summary, got, tc.want)
+			}
+		})
+	}
+}